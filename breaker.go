@@ -0,0 +1,208 @@
+package golangutil
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrBreakerOpen is returned by Request when the per-host circuit breaker
+// is open and the request is short-circuited before dialing.
+var ErrBreakerOpen = errors.New("golangutil: circuit breaker open")
+
+// BreakerState is the state of a single host's circuit breaker.
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// BreakerConfig configures the per-host circuit breaker. It is embedded in
+// Config; a zero value disables the breaker (it never opens).
+type BreakerConfig struct {
+	// FailureRatio is the fraction of failed requests in the rolling
+	// window at or above which the breaker trips open.
+	FailureRatio float64
+	// MinRequestVolume is the minimum number of requests that must have
+	// been observed in the window before the failure ratio is evaluated.
+	MinRequestVolume int
+	// Window is the size of the rolling window used to count requests.
+	Window time.Duration
+	// Cooldown is how long the breaker stays open before allowing a
+	// single half-open probe.
+	Cooldown time.Duration
+	// OnStateChange, if set, is called whenever a host's breaker
+	// transitions between states.
+	OnStateChange func(host string, from, to BreakerState)
+}
+
+func (bc BreakerConfig) enabled() bool {
+	return bc.FailureRatio > 0 && bc.MinRequestVolume > 0
+}
+
+// breakerShardCount is the number of lock shards used to spread contention
+// across hot hosts; it is a power of two so masking can replace modulo.
+const breakerShardCount = 32
+
+// hostBreaker is a sharded registry of per-host circuit breakers. Each
+// shard has its own mutex so two unrelated hosts never block each other.
+type hostBreaker struct {
+	conf   BreakerConfig
+	shards [breakerShardCount]struct {
+		mu    sync.Mutex
+		hosts map[string]*breakerEntry
+	}
+}
+
+type breakerEntry struct {
+	state       BreakerState
+	openedAt    time.Time
+	windowStart time.Time
+	requests    int
+	failures    int
+	probing     bool
+}
+
+func newHostBreaker(conf BreakerConfig) *hostBreaker {
+	hb := &hostBreaker{conf: conf}
+	for i := range hb.shards {
+		hb.shards[i].hosts = make(map[string]*breakerEntry)
+	}
+	return hb
+}
+
+func shardFor(host string) int {
+	h := fnv.New32a()
+	h.Write([]byte(host))
+	return int(h.Sum32()) & (breakerShardCount - 1)
+}
+
+// allow reports whether a request to host may proceed, and returns true for
+// isProbe when it is the single half-open probe attempt.
+func (hb *hostBreaker) allow(host string) (ok bool, isProbe bool) {
+	if !hb.conf.enabled() {
+		return true, false
+	}
+	shard := &hb.shards[shardFor(host)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	e := shard.hosts[host]
+	if e == nil {
+		e = &breakerEntry{windowStart: time.Now()}
+		shard.hosts[host] = e
+	}
+
+	switch e.state {
+	case BreakerOpen:
+		if time.Since(e.openedAt) < hb.conf.Cooldown {
+			return false, false
+		}
+		if e.probing {
+			return false, false
+		}
+		e.probing = true
+		hb.transition(host, e, BreakerHalfOpen)
+		return true, true
+	case BreakerHalfOpen:
+		return false, false
+	default:
+		return true, false
+	}
+}
+
+// record updates a host's rolling window with the outcome of a completed
+// request and trips or resets the breaker as needed.
+func (hb *hostBreaker) record(host string, success bool) {
+	if !hb.conf.enabled() {
+		return
+	}
+	shard := &hb.shards[shardFor(host)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	e := shard.hosts[host]
+	if e == nil {
+		return
+	}
+
+	if e.state == BreakerHalfOpen {
+		e.probing = false
+		if success {
+			hb.transition(host, e, BreakerClosed)
+			e.requests, e.failures = 0, 0
+			e.windowStart = time.Now()
+		} else {
+			e.openedAt = time.Now()
+			hb.transition(host, e, BreakerOpen)
+		}
+		return
+	}
+
+	if hb.conf.Window > 0 && time.Since(e.windowStart) > hb.conf.Window {
+		e.requests, e.failures = 0, 0
+		e.windowStart = time.Now()
+	}
+	e.requests++
+	if !success {
+		e.failures++
+	}
+	if e.state == BreakerClosed && e.requests >= hb.conf.MinRequestVolume {
+		if float64(e.failures)/float64(e.requests) >= hb.conf.FailureRatio {
+			e.openedAt = time.Now()
+			hb.transition(host, e, BreakerOpen)
+		}
+	}
+}
+
+func (hb *hostBreaker) transition(host string, e *breakerEntry, to BreakerState) {
+	from := e.state
+	e.state = to
+	if from != to && hb.conf.OnStateChange != nil {
+		hb.conf.OnStateChange(host, from, to)
+	}
+}
+
+func requestHost(req *http.Request) string {
+	if req.URL == nil {
+		return ""
+	}
+	return req.URL.Host
+}
+
+// reserveSlot checks the circuit breaker and rate limiter for host before
+// an attempt is made. On success it returns a release func that must be
+// called exactly once with the attempt's outcome, so the breaker's
+// bookkeeping (including the half-open single-probe invariant) always
+// balances; on failure it returns the error to surface to the caller
+// (ErrBreakerOpen or a rate limiter error) and a nil release. Request and
+// RequestStream both funnel through this so a fix to the reserve/record
+// sequence only has to happen in one place.
+func (c *HttpClient) reserveSlot(ctx context.Context, host string) (release func(success bool), err error) {
+	if ok, _ := c.breaker.allow(host); !ok {
+		return nil, ErrBreakerOpen
+	}
+	if c.limiters.conf.enabled() {
+		if werr := c.limiters.get(host).Wait(ctx); werr != nil {
+			c.breaker.record(host, false)
+			return nil, werr
+		}
+	}
+	return func(success bool) { c.breaker.record(host, success) }, nil
+}
@@ -0,0 +1,116 @@
+package golangutil
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHostBreakerStateMachine(t *testing.T) {
+	hb := newHostBreaker(BreakerConfig{
+		FailureRatio:     0.5,
+		MinRequestVolume: 2,
+		Window:           time.Minute,
+		Cooldown:         0,
+	})
+	const host = "example.com"
+
+	// Closed -> Open: enough failures in the window trip the breaker.
+	if ok, isProbe := hb.allow(host); !ok || isProbe {
+		t.Fatalf("allow() on fresh host = (%v, %v), want (true, false)", ok, isProbe)
+	}
+	hb.record(host, false)
+	if ok, _ := hb.allow(host); !ok {
+		t.Fatalf("allow() after first failure = false, want true (MinRequestVolume not yet reached)")
+	}
+	hb.record(host, false)
+
+	if state, _, _ := validBreakerState(hb, host); state != BreakerOpen {
+		t.Fatalf("state after tripping failure ratio = %v, want %v", state, BreakerOpen)
+	}
+
+	// Open -> HalfOpen: with Cooldown == 0 the next allow() issues the
+	// single probe.
+	ok, isProbe := hb.allow(host)
+	if !ok || !isProbe {
+		t.Fatalf("allow() after cooldown = (%v, %v), want (true, true) for the half-open probe", ok, isProbe)
+	}
+
+	// While the probe is outstanding, no other attempt may proceed.
+	if ok, _ := hb.allow(host); ok {
+		t.Fatalf("allow() while a half-open probe is outstanding = true, want false")
+	}
+
+	// HalfOpen -> Closed: a successful probe resets the window and closes
+	// the breaker.
+	hb.record(host, true)
+	if ok, isProbe := hb.allow(host); !ok || isProbe {
+		t.Fatalf("allow() after successful probe = (%v, %v), want (true, false)", ok, isProbe)
+	}
+
+	// Drive it back to HalfOpen and fail the probe: HalfOpen -> Open again.
+	hb.record(host, false)
+	hb.record(host, false)
+	ok, isProbe = hb.allow(host)
+	if !ok || !isProbe {
+		t.Fatalf("allow() after re-tripping = (%v, %v), want (true, true)", ok, isProbe)
+	}
+	hb.record(host, false)
+	if state, _, _ := validBreakerState(hb, host); state != BreakerOpen {
+		t.Fatalf("state after failed probe = %v, want %v (breaker should reopen)", state, BreakerOpen)
+	}
+}
+
+// TestHostBreakerConcurrent drives many goroutines through allow()/record()
+// for the same host at once, the way requestWithClient and RequestStream do
+// for concurrent callers. It exercises the single half-open probe invariant
+// under contention; run with -race to catch the class of bug fixed in
+// cf280a1, where a lost record() call could wedge the breaker open forever.
+func TestHostBreakerConcurrent(t *testing.T) {
+	hb := newHostBreaker(BreakerConfig{
+		FailureRatio:     0.5,
+		MinRequestVolume: 5,
+		Window:           time.Millisecond,
+		Cooldown:         0,
+	})
+	const host = "concurrent.example.com"
+	const goroutines = 50
+	const iterations = 200
+
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for i := 0; i < goroutines; i++ {
+		go func(n int) {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				ok, _ := hb.allow(host)
+				if ok {
+					hb.record(host, (n+j)%3 != 0)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// The breaker must have settled into a valid state and still respond
+	// to allow() without deadlocking.
+	if _, _, ok := validBreakerState(hb, host); !ok {
+		t.Fatalf("breaker left host %q in an invalid state after concurrent use", host)
+	}
+}
+
+func validBreakerState(hb *hostBreaker, host string) (BreakerState, bool, bool) {
+	shard := &hb.shards[shardFor(host)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	e := shard.hosts[host]
+	if e == nil {
+		return BreakerClosed, false, true
+	}
+	switch e.state {
+	case BreakerClosed, BreakerOpen, BreakerHalfOpen:
+		return e.state, e.probing, true
+	default:
+		return e.state, e.probing, false
+	}
+}
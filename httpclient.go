@@ -1,16 +1,13 @@
 package golangutil
 
 import (
-	"bytes"
-	"compress/gzip"
 	"context"
 	"crypto/tls"
-	"encoding/json"
+	"crypto/x509"
+	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
 	"net/http"
-	"strings"
 	"time"
 
 	"errors"
@@ -39,13 +36,59 @@ type Config struct {
 	Timeout             time.Duration
 	KeepAlive           time.Duration
 	IdleConnectTimeout  time.Duration
+
+	// MaxRetries is the number of additional attempts made after the first
+	// one fails. Zero (the default) disables retries entirely.
+	MaxRetries int
+	// BaseBackoff is the delay used for the first retry; it doubles on
+	// each subsequent attempt until MaxBackoff is reached.
+	BaseBackoff time.Duration
+	// MaxBackoff caps the computed backoff before jitter is applied.
+	MaxBackoff time.Duration
+	// JitterFraction scales the full-jitter backoff window, e.g. 1.0 means
+	// sleep is chosen uniformly from [0, backoff). Defaults to 1.0 when <= 0.
+	JitterFraction float64
+	// RetryPolicy overrides the default retry decision/backoff logic.
+	RetryPolicy RetryPolicy
+
+	// Breaker configures the per-host circuit breaker. Disabled by default.
+	Breaker BreakerConfig
+	// RateLimit configures the per-host token-bucket rate limiter.
+	// Disabled by default.
+	RateLimit RateLimitConfig
+
+	// MaxResponseBytes caps how much of a response body Request will read
+	// before failing with ErrResponseTooLarge. Zero means unlimited.
+	MaxResponseBytes int64
+
+	// TLSConfig, if set, is used as the base TLS configuration for the
+	// client's transport. InsecureSkipVerify defaults to false, unlike
+	// the client's previous hardcoded behavior; set it explicitly on
+	// TLSConfig to opt back into skipping verification.
+	TLSConfig *tls.Config
+	// RootCAs, if set, is used to verify server certificates instead of
+	// the system root pool.
+	RootCAs *x509.CertPool
+	// ClientCertificates, if set, are presented for mutual TLS / client
+	// certificate pinning.
+	ClientCertificates []tls.Certificate
+
+	// CookieJar, if set, is wired through to http.Client.Jar. Use
+	// NewCookieJar to get a jar backed by the public suffix list.
+	CookieJar http.CookieJar
+	// Redirect controls how the client's underlying http.Client follows
+	// redirects. The zero value is Go's default redirect behavior.
+	Redirect RedirectPolicy
 }
 
 type HttpClient struct {
-	conf      *Config
-	client    *http.Client
-	dialer    *net.Dialer
-	transport *http.Transport
+	conf        *Config
+	client      *http.Client
+	dialer      *net.Dialer
+	transport   *http.Transport
+	breaker     *hostBreaker
+	limiters    *hostLimiters
+	middlewares []Middleware
 }
 
 // NewHTTPClient returns a new instance of httpClient
@@ -56,17 +99,39 @@ func NewHTTPClient(c *Config) *HttpClient {
 	}
 	transport := &http.Transport{
 		DialContext:         dialer.DialContext,
-		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig:     buildTLSConfig(c),
 		MaxIdleConnsPerHost: c.MaxIdleConnsPerHost,
 		IdleConnTimeout:     c.IdleConnectTimeout,
 	}
 	return &HttpClient{
 		conf: c,
 		client: &http.Client{
-			Transport: transport,
-			Timeout:   c.Timeout,
+			Transport:     transport,
+			Timeout:       c.Timeout,
+			Jar:           c.CookieJar,
+			CheckRedirect: c.Redirect.checkRedirect(),
 		},
+		dialer:    dialer,
+		transport: transport,
+		breaker:   newHostBreaker(c.Breaker),
+		limiters:  newHostLimiters(c.RateLimit),
+	}
+}
+
+func buildTLSConfig(c *Config) *tls.Config {
+	var tlsConfig *tls.Config
+	if c.TLSConfig != nil {
+		tlsConfig = c.TLSConfig.Clone()
+	} else {
+		tlsConfig = &tls.Config{}
 	}
+	if c.RootCAs != nil {
+		tlsConfig.RootCAs = c.RootCAs
+	}
+	if len(c.ClientCertificates) > 0 {
+		tlsConfig.Certificates = c.ClientCertificates
+	}
+	return tlsConfig
 }
 
 // Get makes a HTTP GET request to provided URL with context passed in
@@ -84,15 +149,20 @@ func (c *HttpClient) Get(ctx context.Context, url string, headers http.Header) (
 
 // Post makes a HTTP POST request to provided URL with context passed in
 func (c *HttpClient) Post(ctx context.Context, url, contentType string, headers http.Header, param interface{}) (resp []byte, err error) {
-	request, err := http.NewRequest(http.MethodPost, url, reqBody(contentType, param))
+	body, err := reqBody(contentType, param)
 	if err != nil {
-		err = errors.New("GET - request creation failed:" + err.Error())
+		err = errors.New("POST - request body encoding failed:" + err.Error())
+		return
+	}
+	request, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		err = errors.New("POST - request creation failed:" + err.Error())
 		return
 	}
 	if headers == nil {
 		headers = make(http.Header)
 	}
-	headers.Set("Content-Type", contentType)
+	headers.Set("Content-Type", bodyContentType(body, contentType))
 	request.Header = headers
 
 	return c.Request(ctx, request)
@@ -100,16 +170,21 @@ func (c *HttpClient) Post(ctx context.Context, url, contentType string, headers
 
 // Put makes a HTTP PUT request to provided URL with context passed in
 func (c *HttpClient) Put(ctx context.Context, url, contentType string, headers http.Header, param interface{}) (resp []byte, err error) {
-	request, err := http.NewRequest(http.MethodPut, url, reqBody(contentType, param))
+	body, err := reqBody(contentType, param)
 	if err != nil {
-		err = errors.New("GET - request creation failed:" + err.Error())
+		err = errors.New("PUT - request body encoding failed:" + err.Error())
+		return
+	}
+	request, err := http.NewRequest(http.MethodPut, url, body)
+	if err != nil {
+		err = errors.New("PUT - request creation failed:" + err.Error())
 		return
 	}
 
 	if headers == nil {
 		headers = make(http.Header)
 	}
-	headers.Set("Content-Type", contentType)
+	headers.Set("Content-Type", bodyContentType(body, contentType))
 	request.Header = headers
 
 	return c.Request(ctx, request)
@@ -117,16 +192,21 @@ func (c *HttpClient) Put(ctx context.Context, url, contentType string, headers h
 
 // Patch makes a HTTP PATCH request to provided URL with context passed in
 func (c *HttpClient) Patch(ctx context.Context, url, contentType string, headers http.Header, param interface{}) (resp []byte, err error) {
-	request, err := http.NewRequest(http.MethodPatch, url, reqBody(contentType, param))
+	body, err := reqBody(contentType, param)
 	if err != nil {
-		err = errors.New("GET - request creation failed:" + err.Error())
+		err = errors.New("PATCH - request body encoding failed:" + err.Error())
+		return
+	}
+	request, err := http.NewRequest(http.MethodPatch, url, body)
+	if err != nil {
+		err = errors.New("PATCH - request creation failed:" + err.Error())
 		return
 	}
 
 	if headers == nil {
 		headers = make(http.Header)
 	}
-	headers.Set("Content-Type", contentType)
+	headers.Set("Content-Type", bodyContentType(body, contentType))
 	request.Header = headers
 
 	return c.Request(ctx, request)
@@ -150,75 +230,117 @@ func (c *HttpClient) Delete(ctx context.Context, url, contentType string, header
 }
 
 func (c *HttpClient) Request(ctx context.Context, req *http.Request) (resp []byte, err error) {
+	return c.requestWithClient(ctx, req, c.client)
+}
+
+// RequestWithOptions behaves like Request but lets the caller override
+// this attempt's TLS configuration, cookie jar and redirect policy via
+// RequestOption, without affecting any other caller of the same
+// HttpClient.
+func (c *HttpClient) RequestWithOptions(ctx context.Context, req *http.Request, opts ...RequestOption) (resp []byte, err error) {
+	ro := &requestOptions{}
+	for _, opt := range opts {
+		opt(ro)
+	}
+	return c.requestWithClient(ctx, req, c.clientForOptions(ro))
+}
+
+func (c *HttpClient) requestWithClient(ctx context.Context, req *http.Request, httpClient *http.Client) (resp []byte, err error) {
+	body, err := bufferBody(req)
+	if err != nil {
+		err = errors.New("request body buffering failed:" + err.Error())
+		return
+	}
+	policy := c.retryPolicy()
+	host := requestHost(req)
+
 	var (
-		response *http.Response
-		cancel   func()
+		response   *http.Response
+		lastStatus int
+		attempt    int
 	)
-	ctx, cancel = context.WithTimeout(ctx, time.Duration(c.conf.Timeout))
-	defer cancel()
-	response, err = c.client.Do(req.WithContext(ctx))
-	if err != nil {
+	for {
+		release, rerr := c.reserveSlot(ctx, host)
+		if rerr != nil {
+			return nil, &RequestError{Attempts: attempt, StatusCode: lastStatus, Err: rerr}
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, time.Duration(c.conf.Timeout))
+		response, err = httpClient.Do(cloneWithBody(attemptCtx, req, body).WithContext(attemptCtx))
+		cancel()
+
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+			default:
+			}
+		} else {
+			lastStatus = response.StatusCode
+		}
+
+		release(err == nil && lastStatus < http.StatusInternalServerError)
+
+		if response != nil && response.StatusCode >= http.StatusInternalServerError {
+			log.Printf("StatusInternalServerError - status %d", response.StatusCode)
+		}
+
+		if !policy.ShouldRetry(attempt, response, err) {
+			break
+		}
+
+		sleep := policy.Backoff(attempt, response)
+		if response != nil {
+			response.Body.Close()
+			response = nil
+		}
+		attempt++
+
+		timer := time.NewTimer(sleep)
 		select {
 		case <-ctx.Done():
-			err = ctx.Err()
+			timer.Stop()
+			return nil, &RequestError{Attempts: attempt, StatusCode: lastStatus, Err: ctx.Err()}
+		case <-timer.C:
 		}
-		return
+	}
+
+	if err != nil {
+		return nil, &RequestError{Attempts: attempt + 1, StatusCode: lastStatus, Err: err}
 	}
 	defer response.Body.Close()
-	if response.StatusCode >= http.StatusInternalServerError {
-		log.Println("StatusInternalServerError - Status Internal ServerError error(%v)", err)
-		return
+
+	decoded, err := decodeBody(response)
+	if err != nil {
+		return nil, err
 	}
-	if response.Header.Get("Content-Encoding") == "gzip" {
-		compressedReader, e := gzip.NewReader(response.Body)
-		if e != nil {
-			err = e
-			return
-		}
-		resp, err = ioutil.ReadAll(compressedReader)
-	} else {
-		resp, err = ioutil.ReadAll(response.Body)
+	resp, err = readLimited(decoded, c.conf.MaxResponseBytes)
+	if err != nil {
+		return nil, err
 	}
-	return
-}
 
-func reqBody(contentType string, param interface{}) (body io.Reader) {
-	var (
-		err error
-	)
-	if contentType == MIMEPOSTForm {
-		enc, ok := param.(string)
-		if ok {
-			body = strings.NewReader(enc)
-		}
+	if isRetryableFailureStatus(response.StatusCode) {
+		return nil, &HTTPError{StatusCode: response.StatusCode, Body: resp, Header: response.Header}
 	}
-	if contentType == MIMEJSON {
-		buff := new(bytes.Buffer)
-		err = json.NewEncoder(buff).Encode(param)
-		if err != nil {
-			log.Printf("failed to marshal user payload: %v", err)
-			return
-		}
-		body = buff
+	return resp, nil
+}
+
+func reqBody(contentType string, param interface{}) (body io.Reader, err error) {
+	codecMu.RLock()
+	c, ok := codecs[contentType]
+	codecMu.RUnlock()
+	if !ok || c.encode == nil {
+		return nil, fmt.Errorf("golangutil: no encoder registered for content type %q", contentType)
 	}
-	return
+	return c.encode(param)
 }
 
-func readAll(r io.Reader, capacity int64) (b []byte, err error) {
-	buf := bytes.NewBuffer(make([]byte, 0, capacity))
-	// If the buffer overflows, we will get bytes.ErrTooLarge.
-	// Return that as an error. Any other panic remains.
-	defer func() {
-		e := recover()
-		if e == nil {
-			return
-		}
-		if panicErr, ok := e.(error); ok && panicErr == bytes.ErrTooLarge {
-			err = panicErr
-		} else {
-			panic(e)
-		}
-	}()
-	_, err = buf.ReadFrom(r)
-	return buf.Bytes(), err
+// bodyContentType returns the Content-Type that should actually be sent
+// for the given body, which for multipart/form-data differs from the
+// caller-supplied contentType by including the negotiated boundary.
+func bodyContentType(body io.Reader, contentType string) string {
+	if mb, ok := body.(*multipartBody); ok {
+		return mb.contentType
+	}
+	return contentType
 }
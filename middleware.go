@@ -0,0 +1,187 @@
+package golangutil
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware wraps an http.RoundTripper with additional behavior. It has
+// the same shape as e.g. negroni/alice middleware: given the next
+// RoundTripper in the chain, it returns a new one that calls it.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// roundTripperFunc adapts a plain function to the http.RoundTripper
+// interface, mirroring the standard library's http.HandlerFunc pattern.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Use appends one or more middlewares to the client's RoundTripper chain
+// and rebuilds it. Middlewares run in the order supplied: the first one
+// sees the request first and the response last.
+func (c *HttpClient) Use(mw ...func(http.RoundTripper) http.RoundTripper) {
+	for _, m := range mw {
+		c.middlewares = append(c.middlewares, Middleware(m))
+	}
+	c.client.Transport = c.wrapTransport(c.transport)
+}
+
+// wrapTransport re-applies the client's middleware chain on top of base,
+// so any caller that needs a fresh base http.Transport (e.g. a per-request
+// TLS override) doesn't silently drop logging/tracing/metrics/decompress.
+func (c *HttpClient) wrapTransport(base http.RoundTripper) http.RoundTripper {
+	rt := base
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		rt = c.middlewares[i](rt)
+	}
+	return rt
+}
+
+// LoggingMiddleware logs method, URL, status code and latency for every
+// request that passes through it.
+func LoggingMiddleware(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				logger.Printf("http %s %s failed after %s: %v", req.Method, req.URL, time.Since(start), err)
+				return resp, err
+			}
+			logger.Printf("http %s %s -> %d in %s", req.Method, req.URL, resp.StatusCode, time.Since(start))
+			return resp, err
+		})
+	}
+}
+
+// TracingMiddleware starts a span per request and injects a W3C
+// traceparent header so the call can be correlated downstream.
+func TracingMiddleware(tracer trace.Tracer) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			ctx, span := tracer.Start(req.Context(), req.Method+" "+req.URL.Host)
+			defer span.End()
+
+			req = req.WithContext(ctx)
+			req.Header.Set("traceparent", traceparentHeader(span.SpanContext()))
+
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				span.RecordError(err)
+			} else {
+				span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+			}
+			return resp, err
+		})
+	}
+}
+
+func traceparentHeader(sc trace.SpanContext) string {
+	flags := "00"
+	if sc.IsSampled() {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID(), sc.SpanID(), flags)
+}
+
+// MetricsMiddleware records request counts and a latency histogram, both
+// labeled by host, method and status, and an in-flight gauge labeled by
+// host and method.
+func MetricsMiddleware(reg prometheus.Registerer) Middleware {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "golangutil_http_requests_total",
+		Help: "Total HTTP requests made by HttpClient, by host, method and status.",
+	}, []string{"host", "method", "status"})
+	latency := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "golangutil_http_request_duration_seconds",
+		Help:    "HTTP request latency in seconds, by host, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"host", "method", "status"})
+	inFlight := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "golangutil_http_requests_in_flight",
+		Help: "In-flight HTTP requests, by host and method.",
+	}, []string{"host", "method"})
+	reg.MustRegister(requests, latency, inFlight)
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			host := req.URL.Host
+			inFlight.WithLabelValues(host, req.Method).Inc()
+			defer inFlight.WithLabelValues(host, req.Method).Dec()
+
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			status := "error"
+			if resp != nil {
+				status = fmt.Sprintf("%d", resp.StatusCode)
+			}
+			requests.WithLabelValues(host, req.Method, status).Inc()
+			latency.WithLabelValues(host, req.Method, status).Observe(time.Since(start).Seconds())
+			return resp, err
+		})
+	}
+}
+
+// DecompressMiddleware transparently decodes gzip, brotli ("br") and
+// deflate response bodies, stripping Content-Encoding so callers always
+// see plain bytes regardless of how the server compressed them. This
+// complements the inline decoding in HttpClient.Request, which only
+// understands gzip and br, not deflate.
+func DecompressMiddleware() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := next.RoundTrip(req)
+			if err != nil || resp == nil {
+				return resp, err
+			}
+			enc := strings.ToLower(resp.Header.Get("Content-Encoding"))
+			var reader io.Reader
+			switch enc {
+			case "gzip":
+				reader, err = gzip.NewReader(resp.Body)
+			case "br":
+				reader = brotli.NewReader(resp.Body)
+			case "deflate":
+				reader = flate.NewReader(resp.Body)
+			default:
+				return resp, nil
+			}
+			if err != nil {
+				return resp, err
+			}
+			orig := resp.Body
+			resp.Body = &readCloser{Reader: reader, closer: orig}
+			resp.Header.Del("Content-Encoding")
+			resp.Header.Del("Content-Length")
+			resp.ContentLength = -1
+			return resp, nil
+		})
+	}
+}
+
+// readCloser pairs a decompressing io.Reader with the underlying response
+// body so closing it still releases the connection.
+type readCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (r *readCloser) Close() error {
+	return r.closer.Close()
+}
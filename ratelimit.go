@@ -0,0 +1,52 @@
+package golangutil
+
+import (
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimitConfig configures the per-host token-bucket rate limiter. A
+// zero value disables rate limiting.
+type RateLimitConfig struct {
+	// QPS is the sustained number of requests per second allowed per host.
+	QPS float64
+	// Burst is the maximum number of requests admitted in a single burst.
+	Burst int
+}
+
+func (rc RateLimitConfig) enabled() bool {
+	return rc.QPS > 0
+}
+
+// hostLimiters is a sharded registry of per-host rate.Limiter instances,
+// using the same shard count as hostBreaker so hot hosts don't contend on
+// a single mutex.
+type hostLimiters struct {
+	conf   RateLimitConfig
+	shards [breakerShardCount]struct {
+		mu       sync.Mutex
+		limiters map[string]*rate.Limiter
+	}
+}
+
+func newHostLimiters(conf RateLimitConfig) *hostLimiters {
+	hl := &hostLimiters{conf: conf}
+	for i := range hl.shards {
+		hl.shards[i].limiters = make(map[string]*rate.Limiter)
+	}
+	return hl
+}
+
+func (hl *hostLimiters) get(host string) *rate.Limiter {
+	shard := &hl.shards[shardFor(host)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	l, ok := shard.limiters[host]
+	if !ok {
+		l = rate.NewLimiter(rate.Limit(hl.conf.QPS), hl.conf.Burst)
+		shard.limiters[host] = l
+	}
+	return l
+}
@@ -0,0 +1,149 @@
+package golangutil
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy decides whether a request attempt should be retried and, if
+// so, how long to wait before the next attempt. resp and err are the
+// results of the attempt that just finished; resp may be nil when err is
+// non-nil, and vice versa.
+type RetryPolicy interface {
+	ShouldRetry(attempt int, resp *http.Response, err error) bool
+	Backoff(attempt int, resp *http.Response) time.Duration
+}
+
+// defaultRetryPolicy retries on network errors, a per-attempt
+// context.DeadlineExceeded, and HTTP 5xx/429 responses. It honors
+// Retry-After when the server sends one.
+type defaultRetryPolicy struct {
+	maxRetries     int
+	baseBackoff    time.Duration
+	maxBackoff     time.Duration
+	jitterFraction float64
+}
+
+func (p *defaultRetryPolicy) ShouldRetry(attempt int, resp *http.Response, err error) bool {
+	if attempt >= p.maxRetries {
+		return false
+	}
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return true
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			return true
+		}
+		return false
+	}
+	if resp == nil {
+		return false
+	}
+	return isRetryableFailureStatus(resp.StatusCode)
+}
+
+// isRetryableFailureStatus reports whether status is one of the HTTP
+// statuses defaultRetryPolicy treats as a failure worth retrying (5xx or
+// 429). It's also used once retries are exhausted, so a request that
+// failed this way on every attempt surfaces as an HTTPError instead of a
+// silent (body, nil) indistinguishable from a real success.
+func isRetryableFailureStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+func (p *defaultRetryPolicy) Backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if d, ok := retryAfter(resp); ok {
+			return d
+		}
+	}
+	sleep := float64(p.baseBackoff) * math.Pow(2, float64(attempt))
+	if max := float64(p.maxBackoff); p.maxBackoff > 0 && sleep > max {
+		sleep = max
+	}
+	jitter := p.jitterFraction
+	if jitter <= 0 {
+		jitter = 1
+	}
+	return time.Duration(rand.Float64() * jitter * sleep)
+}
+
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// RequestError is returned by HttpClient.Request and RequestStream when no
+// response was finally obtained: a transport error, the context ending, or
+// a rejection by the circuit breaker or rate limiter. A final response
+// with a failure status is reported by Request as *HTTPError instead. It
+// reports how many attempts were made and the status code of the last
+// response received, if any.
+type RequestError struct {
+	Attempts   int
+	StatusCode int
+	Err        error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("request failed after %d attempt(s), last status %d: %v", e.Attempts, e.StatusCode, e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// bufferBody reads req.Body (if any) into memory so it can be replayed on
+// every retry attempt, and returns the captured bytes.
+func bufferBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	defer req.Body.Close()
+	return ioutil.ReadAll(req.Body)
+}
+
+// cloneWithBody returns a copy of req (via req.Clone) bound to ctx, with its body
+// reset to a fresh reader over body so the attempt can be retried.
+func cloneWithBody(ctx context.Context, req *http.Request, body []byte) *http.Request {
+	clone := req.Clone(ctx)
+	if body != nil {
+		clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+		clone.ContentLength = int64(len(body))
+	}
+	return clone
+}
+
+func (c *HttpClient) retryPolicy() RetryPolicy {
+	if c.conf.RetryPolicy != nil {
+		return c.conf.RetryPolicy
+	}
+	return &defaultRetryPolicy{
+		maxRetries:     c.conf.MaxRetries,
+		baseBackoff:    c.conf.BaseBackoff,
+		maxBackoff:     c.conf.MaxBackoff,
+		jitterFraction: c.conf.JitterFraction,
+	}
+}
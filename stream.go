@@ -0,0 +1,155 @@
+package golangutil
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// ErrResponseTooLarge is returned when a response body exceeds
+// Config.MaxResponseBytes.
+var ErrResponseTooLarge = errors.New("golangutil: response body exceeds MaxResponseBytes")
+
+// HTTPError represents a non-transport failure: the server was reached
+// and replied, but with a status Request doesn't treat as success. It lets
+// callers distinguish "the server said no" from network/timeout errors.
+type HTTPError struct {
+	StatusCode int
+	Body       []byte
+	Header     http.Header
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("golangutil: http error, status %d", e.StatusCode)
+}
+
+// decodeBody wraps resp.Body to transparently undo gzip/br content
+// encoding, stripping the header so callers never see compressed bytes.
+func decodeBody(resp *http.Response) (io.ReadCloser, error) {
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip":
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		resp.Header.Del("Content-Encoding")
+		return &readCloser{Reader: gz, closer: resp.Body}, nil
+	case "br":
+		resp.Header.Del("Content-Encoding")
+		return &readCloser{Reader: brotli.NewReader(resp.Body), closer: resp.Body}, nil
+	default:
+		return resp.Body, nil
+	}
+}
+
+// readLimited reads r up to maxBytes and returns ErrResponseTooLarge if the
+// body turns out to be larger than that. maxBytes <= 0 means unlimited.
+func readLimited(r io.Reader, maxBytes int64) ([]byte, error) {
+	if maxBytes <= 0 {
+		return ioutil.ReadAll(r)
+	}
+	limited := io.LimitReader(r, maxBytes+1)
+	b, err := ioutil.ReadAll(limited)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > maxBytes {
+		return nil, ErrResponseTooLarge
+	}
+	return b, nil
+}
+
+// RequestStream behaves like Request but returns the live *http.Response
+// instead of buffering the body, so callers can stream arbitrarily large
+// payloads. The response Body transparently undoes gzip/br encoding; the
+// caller is responsible for closing it. Retries, the circuit breaker and
+// rate limiting all still apply to the attempt(s) leading up to the
+// response that is finally returned.
+func (c *HttpClient) RequestStream(ctx context.Context, req *http.Request) (*http.Response, error) {
+	body, err := bufferBody(req)
+	if err != nil {
+		return nil, errors.New("request body buffering failed:" + err.Error())
+	}
+	policy := c.retryPolicy()
+	host := requestHost(req)
+
+	var (
+		response   *http.Response
+		lastStatus int
+		attempt    int
+	)
+	for {
+		release, rerr := c.reserveSlot(ctx, host)
+		if rerr != nil {
+			return nil, &RequestError{Attempts: attempt, StatusCode: lastStatus, Err: rerr}
+		}
+
+		response, err = c.client.Do(cloneWithBody(ctx, req, body))
+		if err == nil {
+			lastStatus = response.StatusCode
+		}
+		release(err == nil && response != nil && response.StatusCode < http.StatusInternalServerError)
+		if !policy.ShouldRetry(attempt, response, err) {
+			break
+		}
+		if response != nil {
+			response.Body.Close()
+		}
+		sleep := policy.Backoff(attempt, response)
+		attempt++
+		timer := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, &RequestError{Attempts: attempt, StatusCode: lastStatus, Err: ctx.Err()}
+		case <-timer.C:
+		}
+	}
+	if err != nil {
+		return nil, &RequestError{Attempts: attempt + 1, StatusCode: lastStatus, Err: err}
+	}
+
+	decoded, err := decodeBody(response)
+	if err != nil {
+		response.Body.Close()
+		return nil, err
+	}
+	response.Body = decoded
+	return response, nil
+}
+
+// GetStream issues a streaming GET; see RequestStream.
+func (c *HttpClient) GetStream(ctx context.Context, url string, headers http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.New("GET - request creation failed:" + err.Error())
+	}
+	req.Header = headers
+	return c.RequestStream(ctx, req)
+}
+
+// PostStream issues a streaming POST; see RequestStream.
+func (c *HttpClient) PostStream(ctx context.Context, url, contentType string, headers http.Header, param interface{}) (*http.Response, error) {
+	body, err := reqBody(contentType, param)
+	if err != nil {
+		return nil, errors.New("POST - request body encoding failed:" + err.Error())
+	}
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return nil, errors.New("POST - request creation failed:" + err.Error())
+	}
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	headers.Set("Content-Type", bodyContentType(body, contentType))
+	req.Header = headers
+	return c.RequestStream(ctx, req)
+}
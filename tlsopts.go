@@ -0,0 +1,125 @@
+package golangutil
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// RedirectMode selects how an HttpClient follows HTTP redirects.
+type RedirectMode int
+
+const (
+	// RedirectModeDefault uses Go's standard redirect behavior (follow up
+	// to 10 redirects).
+	RedirectModeDefault RedirectMode = iota
+	// RedirectModeNone disables redirect following entirely.
+	RedirectModeNone
+	// RedirectModeUpToN follows at most MaxRedirects redirects.
+	RedirectModeUpToN
+	// RedirectModeSameHostOnly follows redirects only while the target
+	// host matches the original request's host.
+	RedirectModeSameHostOnly
+)
+
+// RedirectPolicy configures HttpClient's redirect following via
+// http.Client.CheckRedirect.
+type RedirectPolicy struct {
+	Mode RedirectMode
+	// MaxRedirects is used when Mode is RedirectModeUpToN.
+	MaxRedirects int
+}
+
+func (p RedirectPolicy) checkRedirect() func(req *http.Request, via []*http.Request) error {
+	switch p.Mode {
+	case RedirectModeNone:
+		return func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	case RedirectModeUpToN:
+		return func(req *http.Request, via []*http.Request) error {
+			if len(via) > p.MaxRedirects {
+				return fmt.Errorf("golangutil: stopped after %d redirects", p.MaxRedirects)
+			}
+			return nil
+		}
+	case RedirectModeSameHostOnly:
+		return func(req *http.Request, via []*http.Request) error {
+			if len(via) == 0 {
+				return nil
+			}
+			if req.URL.Host != via[0].URL.Host {
+				return fmt.Errorf("golangutil: refusing cross-host redirect to %s", req.URL.Host)
+			}
+			return nil
+		}
+	default:
+		return nil
+	}
+}
+
+// NewCookieJar returns a cookie jar backed by the public suffix list,
+// suitable for Config.CookieJar.
+func NewCookieJar() (http.CookieJar, error) {
+	return cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+}
+
+// requestOptions holds the per-request overrides accumulated by
+// RequestOption values passed to RequestWithOptions.
+type requestOptions struct {
+	tlsConfig *tls.Config
+	jar       http.CookieJar
+	redirect  *RedirectPolicy
+}
+
+// RequestOption overrides one aspect of a single RequestWithOptions call
+// without affecting the HttpClient's shared configuration.
+type RequestOption func(*requestOptions)
+
+// WithTLSConfig overrides the TLS configuration used for this request.
+func WithTLSConfig(cfg *tls.Config) RequestOption {
+	return func(ro *requestOptions) { ro.tlsConfig = cfg }
+}
+
+// WithCookieJar overrides the cookie jar used for this request.
+func WithCookieJar(jar http.CookieJar) RequestOption {
+	return func(ro *requestOptions) { ro.jar = jar }
+}
+
+// WithRedirectPolicy overrides the redirect policy used for this request.
+func WithRedirectPolicy(p RedirectPolicy) RequestOption {
+	return func(ro *requestOptions) { ro.redirect = &p }
+}
+
+// clientForOptions returns c.client unchanged when ro carries no
+// overrides, or a shallow clone with the requested overrides applied so
+// concurrent callers using the shared client are unaffected.
+func (c *HttpClient) clientForOptions(ro *requestOptions) *http.Client {
+	if ro.tlsConfig == nil && ro.jar == nil && ro.redirect == nil {
+		return c.client
+	}
+
+	transport := http.RoundTripper(c.client.Transport)
+	if ro.tlsConfig != nil {
+		base := c.transport.Clone()
+		base.TLSClientConfig = ro.tlsConfig
+		transport = c.wrapTransport(base)
+	}
+
+	client := &http.Client{
+		Transport:     transport,
+		Timeout:       c.client.Timeout,
+		Jar:           c.client.Jar,
+		CheckRedirect: c.client.CheckRedirect,
+	}
+	if ro.jar != nil {
+		client.Jar = ro.jar
+	}
+	if ro.redirect != nil {
+		client.CheckRedirect = ro.redirect.checkRedirect()
+	}
+	return client
+}